@@ -0,0 +1,138 @@
+//+build linux
+
+package nfqueue
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/mdlayher/netlink"
+)
+
+// AttrKey indexes the fields that can be present in a Msg, as decoded from
+// the attributes of a NFQNL_MSG_PACKET netlink message.
+type AttrKey uint8
+
+// Keys into a Msg.
+const (
+	AttrPacketID AttrKey = iota
+	AttrHwProtocol
+	AttrHook
+	AttrMark
+	AttrPayload
+	AttrCt
+	AttrCtInfo
+	AttrTimestamp
+	AttrIfIndexInDev
+	AttrIfIndexOutDev
+	AttrIfIndexPhysInDev
+	AttrIfIndexPhysOutDev
+	AttrHwAddr
+	AttrCapLen
+	AttrSkbInfo
+	AttrSecCtx
+	AttrUID
+	AttrGID
+	AttrL2HDR
+	// AttrPacket holds the decoded L3/L4 headers of AttrPayload, populated
+	// only when Register was called WithDecoder.
+	AttrPacket
+)
+
+// Msg carries the attributes the kernel attached to a queued packet.
+type Msg map[AttrKey]interface{}
+
+// HookFunc is called for every packet received on a queue. Returning a
+// non-zero value from the callback stops the receive loop started by
+// Register. If Register was called WithPooledMsg, m is reused for the next
+// packet as soon as the callback returns and must not be retained.
+type HookFunc func(m Msg) int
+
+// extractAttributes decodes the attributes of a NFQNL_MSG_PACKET into msg.
+// If msg is nil, a fresh Msg is allocated; otherwise the caller's map is
+// cleared and reused, which is how Register's hot path avoids an
+// allocation per queued packet when pooling is enabled via WithPooledMsg.
+func extractAttributes(data []byte, decoder Decoder, msg Msg) (Msg, error) {
+	if msg == nil {
+		msg = make(Msg)
+	} else {
+		for k := range msg {
+			delete(msg, k)
+		}
+	}
+	ad, err := netlink.NewAttributeDecoder(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	ad.ByteOrder = binary.BigEndian
+	for ad.Next() {
+		switch ad.Type() {
+		case nfQaPacketHdr:
+			hdr := ad.Bytes()
+			if len(hdr) == 7 {
+				msg[AttrPacketID] = binary.BigEndian.Uint32(hdr[0:4])
+				msg[AttrHwProtocol] = binary.BigEndian.Uint16(hdr[4:6])
+				msg[AttrHook] = hdr[6]
+			}
+		case nfQaMark:
+			msg[AttrMark] = ad.Uint32()
+		case nfQaPayload:
+			payload := ad.Bytes()
+			msg[AttrPayload] = payload
+			if decoder != nil {
+				if p, err := decoder(payload); err == nil {
+					msg[AttrPacket] = p
+				}
+			}
+		case nfQaCt:
+			ct, err := decodeConntrack(ad.Bytes())
+			if err == nil {
+				msg[AttrCt] = ct
+			}
+		case nfQaCtInfo:
+			msg[AttrCtInfo] = CtInfo(ad.Uint32())
+		case nfQaTimestamp:
+			b := ad.Bytes()
+			if len(b) == 16 {
+				msg[AttrTimestamp] = time.Unix(
+					int64(binary.BigEndian.Uint64(b[0:8])),
+					int64(binary.BigEndian.Uint64(b[8:16]))*1000,
+				)
+			}
+		case nfQaIfIndexIndev:
+			msg[AttrIfIndexInDev] = ad.Uint32()
+		case nfQaIfIndexOutdev:
+			msg[AttrIfIndexOutDev] = ad.Uint32()
+		case nfQaIfIndexPhysIndev:
+			msg[AttrIfIndexPhysInDev] = ad.Uint32()
+		case nfQaIfIndexPhysOutdev:
+			msg[AttrIfIndexPhysOutDev] = ad.Uint32()
+		case nfQaHwAddr:
+			b := ad.Bytes()
+			// struct nfqnl_msg_packet_hw { __be16 hw_addrlen; __u8 _pad[2]; __u8 hw_addr[8]; }
+			if len(b) >= 4 {
+				hwAddrLen := int(binary.BigEndian.Uint16(b[0:2]))
+				if hwAddrLen > 0 && 4+hwAddrLen <= len(b) {
+					msg[AttrHwAddr] = net.HardwareAddr(b[4 : 4+hwAddrLen])
+				}
+			}
+		case nfQaCapLen:
+			msg[AttrCapLen] = ad.Uint32()
+		case nfQaSkbInfo:
+			msg[AttrSkbInfo] = ad.Uint32()
+		case nfQaSecCtx:
+			msg[AttrSecCtx] = ad.String()
+		case nfQaUID:
+			msg[AttrUID] = ad.Uint32()
+		case nfQaGID:
+			msg[AttrGID] = ad.Uint32()
+		case nfQaL2Hdr:
+			msg[AttrL2HDR] = ad.Bytes()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
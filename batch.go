@@ -0,0 +1,58 @@
+//+build linux
+
+package nfqueue
+
+// VerdictBatch accumulates a run of packet ids sharing the same verdict and
+// flushes them to the kernel as a single NFQNL_MSG_VERDICT_BATCH message
+// (the kernel applies the verdict to every queued packet up to the given
+// id), instead of paying a netlink round-trip per packet. Pairing this with
+// NfQaCfgFlagGSO lets the kernel hand back larger, already-coalesced
+// NFQA_PAYLOAD frames, further cutting the number of packets that need a
+// verdict of their own.
+type VerdictBatch struct {
+	nfqueue *Nfqueue
+	verdict int
+	highID  int
+	ack     bool
+}
+
+// NewVerdictBatch returns an empty VerdictBatch for verdict. When ack is
+// false, Flush sends the batch verdict without HeaderFlagsAcknowledge,
+// trading the kernel's acknowledgement for one less round-trip per flush.
+func (nfqueue *Nfqueue) NewVerdictBatch(verdict int, ack bool) *VerdictBatch {
+	return &VerdictBatch{nfqueue: nfqueue, verdict: verdict, highID: -1, ack: ack}
+}
+
+// Add queues id to be covered by the batch's verdict. Ids must be added in
+// increasing order, matching the order packets are handed to the HookFunc.
+func (b *VerdictBatch) Add(id int) error {
+	if id < b.highID {
+		return ErrOutOfOrderID
+	}
+	b.highID = id
+	return nil
+}
+
+// Flush sends the accumulated verdict for every id up to the highest one
+// added since the last Flush. It is a no-op if nothing has been added.
+func (b *VerdictBatch) Flush() error {
+	if b.highID < 0 {
+		return nil
+	}
+	_, err := b.nfqueue.setVerdictAck(b.highID, b.verdict, true, nil, b.ack)
+	if err != nil {
+		return err
+	}
+	b.highID = -1
+	return nil
+}
+
+// WithPooledMsg makes Register reuse a single scratch Msg across the
+// receive loop's tight call to Con.Receive() instead of allocating a fresh
+// map per queued packet, trading the hot path's per-packet allocation for
+// the restriction that the HookFunc must not retain the Msg it's handed.
+func WithPooledMsg() Option {
+	return func(o *options) {
+		o.pooled = true
+	}
+}
@@ -0,0 +1,230 @@
+//+build linux
+
+package nfqueue
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/mdlayher/netlink"
+)
+
+// CTA_* attribute types, see include/uapi/linux/netfilter/nfnetlink_conntrack.h
+const (
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaStatus     = 3
+	ctaMark       = 8
+	ctaID         = 12
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+)
+
+// CtInfo describes the relationship between a queued packet and the
+// conntrack entry attached to it, as reported via NFQA_CT_INFO. Values
+// mirror enum ip_conntrack_info.
+type CtInfo uint32
+
+// Possible values of CtInfo.
+const (
+	CtInfoEstablished CtInfo = iota
+	CtInfoRelated
+	CtInfoNew
+	CtInfoEstablishedReply
+	CtInfoRelatedReply
+	CtInfoNewReply
+)
+
+// CtTuple is one direction (original or reply) of a conntrack entry's
+// tuple, as decoded from CTA_TUPLE_ORIG / CTA_TUPLE_REPLY.
+type CtTuple struct {
+	Proto   uint8
+	SrcIP   net.IP
+	DstIP   net.IP
+	SrcPort uint16
+	DstPort uint16
+}
+
+// Conntrack is the connection-tracking entry the kernel attaches to a
+// queued packet via NFQA_CT when NfQaCfgFlagConntrack is set on the queue.
+type Conntrack struct {
+	Orig   CtTuple
+	Reply  CtTuple
+	Mark   uint32
+	ID     uint32
+	Status uint32
+}
+
+func decodeConntrack(b []byte) (*Conntrack, error) {
+	var ct Conntrack
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+	ad.ByteOrder = binary.BigEndian
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaTupleOrig:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				ct.Orig = decodeCtTuple(nad)
+				return nil
+			})
+		case ctaTupleReply:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				ct.Reply = decodeCtTuple(nad)
+				return nil
+			})
+		case ctaMark:
+			ct.Mark = ad.Uint32()
+		case ctaID:
+			ct.ID = ad.Uint32()
+		case ctaStatus:
+			ct.Status = ad.Uint32()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	return &ct, nil
+}
+
+func decodeCtTuple(ad *netlink.AttributeDecoder) CtTuple {
+	ad.ByteOrder = binary.BigEndian
+	var t CtTuple
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaTupleIP:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				nad.ByteOrder = binary.BigEndian
+				for nad.Next() {
+					switch nad.Type() {
+					case ctaIPv4Src, ctaIPv6Src:
+						t.SrcIP = append(net.IP(nil), nad.Bytes()...)
+					case ctaIPv4Dst, ctaIPv6Dst:
+						t.DstIP = append(net.IP(nil), nad.Bytes()...)
+					}
+				}
+				return nil
+			})
+		case ctaTupleProto:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				nad.ByteOrder = binary.BigEndian
+				for nad.Next() {
+					switch nad.Type() {
+					case ctaProtoNum:
+						t.Proto = nad.Uint8()
+					case ctaProtoSrcPort:
+						t.SrcPort = nad.Uint16()
+					case ctaProtoDstPort:
+						t.DstPort = nad.Uint16()
+					}
+				}
+				return nil
+			})
+		}
+	}
+	return t
+}
+
+func marshalCtTuple(typ uint16, t CtTuple) (netlink.Attribute, error) {
+	srcIP, dstIP := t.SrcIP, t.DstIP
+	ipType := uint16(ctaIPv4Src)
+	if v4 := t.SrcIP.To4(); v4 != nil {
+		srcIP, dstIP = v4, t.DstIP.To4()
+	} else {
+		ipType = ctaIPv6Src
+	}
+	dstType := ipType + 1
+
+	ipAttrs, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: ipType, Data: srcIP},
+		{Type: dstType, Data: dstIP},
+	})
+	if err != nil {
+		return netlink.Attribute{}, err
+	}
+
+	srcPort := make([]byte, 2)
+	binary.BigEndian.PutUint16(srcPort, t.SrcPort)
+	dstPort := make([]byte, 2)
+	binary.BigEndian.PutUint16(dstPort, t.DstPort)
+	protoAttrs, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: ctaProtoNum, Data: []byte{t.Proto}},
+		{Type: ctaProtoSrcPort, Data: srcPort},
+		{Type: ctaProtoDstPort, Data: dstPort},
+	})
+	if err != nil {
+		return netlink.Attribute{}, err
+	}
+
+	tupleAttrs, err := netlink.MarshalAttributes([]netlink.Attribute{
+		{Type: ctaTupleIP, Data: ipAttrs},
+		{Type: ctaTupleProto, Data: protoAttrs},
+	})
+	if err != nil {
+		return netlink.Attribute{}, err
+	}
+	return netlink.Attribute{Type: typ, Data: tupleAttrs}, nil
+}
+
+// marshalConntrack encodes ct as a NFQA_CT attribute payload so a modified
+// conntrack mark/status can be attached to a packet when its verdict is
+// set, letting callers label a flow as it is released.
+func marshalConntrack(ct *Conntrack) ([]byte, error) {
+	var attrs []netlink.Attribute
+
+	if ct.Orig.SrcIP != nil {
+		orig, err := marshalCtTuple(ctaTupleOrig, ct.Orig)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, orig)
+	}
+	if ct.Reply.SrcIP != nil {
+		reply, err := marshalCtTuple(ctaTupleReply, ct.Reply)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, reply)
+	}
+
+	mark := make([]byte, 4)
+	binary.BigEndian.PutUint32(mark, ct.Mark)
+	attrs = append(attrs, netlink.Attribute{Type: ctaMark, Data: mark})
+
+	status := make([]byte, 4)
+	binary.BigEndian.PutUint32(status, ct.Status)
+	attrs = append(attrs, netlink.Attribute{Type: ctaStatus, Data: status})
+
+	return netlink.MarshalAttributes(attrs)
+}
+
+// SetVerdictModifyCt signals the kernel the next action for a specified
+// packet id and attaches ct as the NFQA_CT payload, letting the kernel
+// apply a modified mark or status to the flow as the packet is released.
+// Only ct's tuples, mark and status are sent; its ID is not, and no
+// conntrack helper or label is attached. Pair this with SetFlag(NfQaCfgFlagConntrack) so the kernel
+// attaches conntrack entries to queued packets in the first place.
+func (nfqueue *Nfqueue) SetVerdictModifyCt(id, verdict int, ct *Conntrack) error {
+	if ct == nil {
+		return ErrNilConntrack
+	}
+	ctData, err := marshalConntrack(ct)
+	if err != nil {
+		return err
+	}
+	_, err = nfqueue.setVerdict(id, verdict, false, []netlink.Attribute{
+		{Type: nfQaCt, Data: ctData},
+	})
+	return err
+}
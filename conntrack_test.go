@@ -0,0 +1,97 @@
+//+build linux
+
+package nfqueue
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConntrackRoundTripIPv4(t *testing.T) {
+	ct := Conntrack{
+		Orig: CtTuple{
+			Proto:   6,
+			SrcIP:   net.ParseIP("192.0.2.1"),
+			DstIP:   net.ParseIP("192.0.2.2"),
+			SrcPort: 1111,
+			DstPort: 80,
+		},
+		Reply: CtTuple{
+			Proto:   6,
+			SrcIP:   net.ParseIP("192.0.2.2"),
+			DstIP:   net.ParseIP("192.0.2.1"),
+			SrcPort: 80,
+			DstPort: 1111,
+		},
+		Mark:   0xdeadbeef,
+		Status: 0x1,
+	}
+
+	data, err := marshalConntrack(&ct)
+	if err != nil {
+		t.Fatalf("marshalConntrack: %v", err)
+	}
+
+	got, err := decodeConntrack(data)
+	if err != nil {
+		t.Fatalf("decodeConntrack: %v", err)
+	}
+
+	if len(got.Orig.SrcIP) != net.IPv4len || !got.Orig.SrcIP.Equal(ct.Orig.SrcIP) {
+		t.Fatalf("orig src ip = %v (len %d), want %v (4 bytes)", got.Orig.SrcIP, len(got.Orig.SrcIP), ct.Orig.SrcIP)
+	}
+	if !got.Orig.DstIP.Equal(ct.Orig.DstIP) {
+		t.Fatalf("orig dst ip = %v, want %v", got.Orig.DstIP, ct.Orig.DstIP)
+	}
+	if got.Orig.Proto != ct.Orig.Proto || got.Orig.SrcPort != ct.Orig.SrcPort || got.Orig.DstPort != ct.Orig.DstPort {
+		t.Fatalf("orig tuple = %+v, want %+v", got.Orig, ct.Orig)
+	}
+	if !got.Reply.SrcIP.Equal(ct.Reply.SrcIP) || !got.Reply.DstIP.Equal(ct.Reply.DstIP) {
+		t.Fatalf("reply tuple IPs = %+v, want %+v", got.Reply, ct.Reply)
+	}
+	if got.Mark != ct.Mark {
+		t.Fatalf("mark = %#x, want %#x", got.Mark, ct.Mark)
+	}
+	if got.Status != ct.Status {
+		t.Fatalf("status = %#x, want %#x", got.Status, ct.Status)
+	}
+}
+
+func TestConntrackRoundTripIPv6(t *testing.T) {
+	ct := Conntrack{
+		Orig: CtTuple{
+			Proto:   17,
+			SrcIP:   net.ParseIP("2001:db8::1"),
+			DstIP:   net.ParseIP("2001:db8::2"),
+			SrcPort: 5000,
+			DstPort: 53,
+		},
+	}
+
+	data, err := marshalConntrack(&ct)
+	if err != nil {
+		t.Fatalf("marshalConntrack: %v", err)
+	}
+
+	got, err := decodeConntrack(data)
+	if err != nil {
+		t.Fatalf("decodeConntrack: %v", err)
+	}
+
+	if len(got.Orig.SrcIP) != net.IPv6len || !got.Orig.SrcIP.Equal(ct.Orig.SrcIP) {
+		t.Fatalf("orig src ip = %v (len %d), want %v (16 bytes)", got.Orig.SrcIP, len(got.Orig.SrcIP), ct.Orig.SrcIP)
+	}
+	if !got.Orig.DstIP.Equal(ct.Orig.DstIP) {
+		t.Fatalf("orig dst ip = %v, want %v", got.Orig.DstIP, ct.Orig.DstIP)
+	}
+	if got.Orig.Proto != ct.Orig.Proto || got.Orig.SrcPort != ct.Orig.SrcPort || got.Orig.DstPort != ct.Orig.DstPort {
+		t.Fatalf("orig tuple = %+v, want %+v", got.Orig, ct.Orig)
+	}
+}
+
+func TestSetVerdictModifyCtNilConntrack(t *testing.T) {
+	var nf Nfqueue
+	if err := nf.SetVerdictModifyCt(1, NfAccept, nil); err != ErrNilConntrack {
+		t.Fatalf("err = %v, want ErrNilConntrack", err)
+	}
+}
@@ -0,0 +1,141 @@
+//+build linux
+
+package nfqueue
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// IPv6 extension header types that precede the true L4 header and must be
+// walked past, see RFC 8200 section 4.1.
+const (
+	ipv6ExtHopByHop = 0
+	ipv6ExtRouting  = 43
+	ipv6ExtFragment = 44
+	ipv6ExtDestOpts = 60
+	ipv6ExtMobility = 135
+)
+
+// Packet holds the L3/L4 metadata decoded from a queued packet's payload,
+// populated under AttrPacket when Register was called WithDecoder.
+type Packet struct {
+	SrcIP    net.IP
+	DstIP    net.IP
+	Protocol uint8
+	SrcPort  uint16
+	DstPort  uint16
+	TCPFlags uint8
+	ICMPType uint8
+	ICMPCode uint8
+}
+
+// Decoder parses the raw L3 packet carried in a NFQA_PAYLOAD attribute into
+// a Packet.
+type Decoder func(payload []byte) (*Packet, error)
+
+// options configures the optional behaviour of Register.
+type options struct {
+	decoder Decoder
+	pooled  bool
+}
+
+// Option configures optional behaviour of Register.
+type Option func(*options)
+
+// WithDecoder makes Register decode every queued packet's payload with d
+// and populate AttrPacket in the Msg handed to the HookFunc. Decoding is
+// opt-in: callers that only need the raw AttrPayload bytes shouldn't pay
+// for a header parse on every packet.
+func WithDecoder(d Decoder) Option {
+	return func(o *options) {
+		o.decoder = d
+	}
+}
+
+// DecodeL4 is the default Decoder. It understands IPv4 and IPv6 framing and
+// decodes the TCP, UDP and ICMP/ICMPv6 headers on top.
+func DecodeL4(payload []byte) (*Packet, error) {
+	if len(payload) < 1 {
+		return nil, ErrUnexpMsg
+	}
+
+	var p Packet
+	var l4 []byte
+
+	switch payload[0] >> 4 {
+	case 4:
+		hdr, err := ipv4.ParseHeader(payload)
+		if err != nil {
+			return nil, errors.Wrap(ErrUnexpMsg, err.Error())
+		}
+		p.Protocol = uint8(hdr.Protocol)
+		p.SrcIP = hdr.Src
+		p.DstIP = hdr.Dst
+		if len(payload) > hdr.Len {
+			l4 = payload[hdr.Len:]
+		}
+	case 6:
+		hdr, err := ipv6.ParseHeader(payload)
+		if err != nil {
+			return nil, errors.Wrap(ErrUnexpMsg, err.Error())
+		}
+		p.SrcIP = hdr.Src
+		p.DstIP = hdr.Dst
+
+		// Walk past any extension headers so Protocol/l4 land on the true
+		// L4 header instead of e.g. a Hop-by-Hop Options header.
+		nextHeader, offset := hdr.NextHeader, 40
+	walk:
+		for {
+			switch nextHeader {
+			case ipv6ExtHopByHop, ipv6ExtRouting, ipv6ExtDestOpts, ipv6ExtMobility:
+				if offset+2 > len(payload) {
+					return &p, nil
+				}
+				nextHeader = int(payload[offset])
+				offset += (int(payload[offset+1]) + 1) * 8
+			case ipv6ExtFragment:
+				if offset+8 > len(payload) {
+					return &p, nil
+				}
+				nextHeader = int(payload[offset])
+				offset += 8
+			default:
+				break walk
+			}
+		}
+		p.Protocol = uint8(nextHeader)
+		if offset < len(payload) {
+			l4 = payload[offset:]
+		}
+	default:
+		return nil, ErrUnexpMsg
+	}
+
+	switch p.Protocol {
+	case unix.IPPROTO_TCP:
+		if len(l4) >= 14 {
+			p.SrcPort = binary.BigEndian.Uint16(l4[0:2])
+			p.DstPort = binary.BigEndian.Uint16(l4[2:4])
+			p.TCPFlags = l4[13]
+		}
+	case unix.IPPROTO_UDP:
+		if len(l4) >= 4 {
+			p.SrcPort = binary.BigEndian.Uint16(l4[0:2])
+			p.DstPort = binary.BigEndian.Uint16(l4[2:4])
+		}
+	case unix.IPPROTO_ICMP, unix.IPPROTO_ICMPV6:
+		if len(l4) >= 2 {
+			p.ICMPType = l4[0]
+			p.ICMPCode = l4[1]
+		}
+	}
+
+	return &p, nil
+}
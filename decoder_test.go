@@ -0,0 +1,155 @@
+//+build linux
+
+package nfqueue
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func buildIPv4(t *testing.T, proto uint8, src, dst net.IP, l4 []byte) []byte {
+	t.Helper()
+	b := make([]byte, 20+len(l4))
+	b[0] = 0x45 // version 4, IHL 5 (20 bytes, no options)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64 // TTL
+	b[9] = proto
+	copy(b[12:16], src.To4())
+	copy(b[16:20], dst.To4())
+	copy(b[20:], l4)
+	return b
+}
+
+func buildIPv6(t *testing.T, nextHeader uint8, src, dst net.IP, rest []byte) []byte {
+	t.Helper()
+	b := make([]byte, 40+len(rest))
+	b[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(rest)))
+	b[6] = nextHeader
+	b[7] = 64 // hop limit
+	copy(b[8:24], src.To16())
+	copy(b[24:40], dst.To16())
+	copy(b[40:], rest)
+	return b
+}
+
+func buildTCP(t *testing.T, srcPort, dstPort uint16, flags uint8) []byte {
+	t.Helper()
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b[0:2], srcPort)
+	binary.BigEndian.PutUint16(b[2:4], dstPort)
+	b[12] = 5 << 4 // data offset: 5 words
+	b[13] = flags
+	return b
+}
+
+func buildUDP(t *testing.T, srcPort, dstPort uint16, payload []byte) []byte {
+	t.Helper()
+	b := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(b[0:2], srcPort)
+	binary.BigEndian.PutUint16(b[2:4], dstPort)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+	copy(b[8:], payload)
+	return b
+}
+
+func TestDecodeL4IPv4TCP(t *testing.T) {
+	src, dst := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	payload := buildIPv4(t, unix.IPPROTO_TCP, src, dst, buildTCP(t, 1234, 443, 0x12))
+
+	p, err := DecodeL4(payload)
+	if err != nil {
+		t.Fatalf("DecodeL4: %v", err)
+	}
+	if !p.SrcIP.Equal(src) || !p.DstIP.Equal(dst) {
+		t.Fatalf("src/dst = %v/%v, want %v/%v", p.SrcIP, p.DstIP, src, dst)
+	}
+	if p.Protocol != unix.IPPROTO_TCP {
+		t.Fatalf("protocol = %d, want %d", p.Protocol, unix.IPPROTO_TCP)
+	}
+	if p.SrcPort != 1234 || p.DstPort != 443 {
+		t.Fatalf("ports = %d/%d, want 1234/443", p.SrcPort, p.DstPort)
+	}
+	if p.TCPFlags != 0x12 {
+		t.Fatalf("tcp flags = %#x, want 0x12", p.TCPFlags)
+	}
+}
+
+func TestDecodeL4IPv4UDP(t *testing.T) {
+	src, dst := net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2")
+	payload := buildIPv4(t, unix.IPPROTO_UDP, src, dst, buildUDP(t, 5353, 53, nil))
+
+	p, err := DecodeL4(payload)
+	if err != nil {
+		t.Fatalf("DecodeL4: %v", err)
+	}
+	if p.SrcPort != 5353 || p.DstPort != 53 {
+		t.Fatalf("ports = %d/%d, want 5353/53", p.SrcPort, p.DstPort)
+	}
+}
+
+func TestDecodeL4IPv4ICMP(t *testing.T) {
+	src, dst := net.ParseIP("198.51.100.1"), net.ParseIP("198.51.100.2")
+	icmp := []byte{8, 0, 0, 0} // echo request, code 0
+	payload := buildIPv4(t, unix.IPPROTO_ICMP, src, dst, icmp)
+
+	p, err := DecodeL4(payload)
+	if err != nil {
+		t.Fatalf("DecodeL4: %v", err)
+	}
+	if p.ICMPType != 8 || p.ICMPCode != 0 {
+		t.Fatalf("icmp type/code = %d/%d, want 8/0", p.ICMPType, p.ICMPCode)
+	}
+}
+
+func TestDecodeL4IPv6UDP(t *testing.T) {
+	src, dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	payload := buildIPv6(t, unix.IPPROTO_UDP, src, dst, buildUDP(t, 5000, 53, nil))
+
+	p, err := DecodeL4(payload)
+	if err != nil {
+		t.Fatalf("DecodeL4: %v", err)
+	}
+	if !p.SrcIP.Equal(src) || !p.DstIP.Equal(dst) {
+		t.Fatalf("src/dst = %v/%v, want %v/%v", p.SrcIP, p.DstIP, src, dst)
+	}
+	if p.Protocol != unix.IPPROTO_UDP {
+		t.Fatalf("protocol = %d, want %d", p.Protocol, unix.IPPROTO_UDP)
+	}
+	if p.SrcPort != 5000 || p.DstPort != 53 {
+		t.Fatalf("ports = %d/%d, want 5000/53", p.SrcPort, p.DstPort)
+	}
+}
+
+// TestDecodeL4IPv6ExtensionHeaderChain walks a Hop-by-Hop Options header
+// followed by a Fragment header before landing on the UDP payload, proving
+// DecodeL4 doesn't mistake an extension header's type for the L4 protocol.
+func TestDecodeL4IPv6ExtensionHeaderChain(t *testing.T) {
+	udp := buildUDP(t, 4000, 4000, nil)
+
+	fragment := make([]byte, 8)
+	fragment[0] = unix.IPPROTO_UDP // next header after the fragment header
+	rest := append(fragment, udp...)
+
+	hopByHop := make([]byte, 8)
+	hopByHop[0] = ipv6ExtFragment // next header after hop-by-hop
+	hopByHop[1] = 0               // hdr ext len 0 => 8 byte header
+	rest = append(hopByHop, rest...)
+
+	src, dst := net.ParseIP("2001:db8::10"), net.ParseIP("2001:db8::20")
+	payload := buildIPv6(t, ipv6ExtHopByHop, src, dst, rest)
+
+	p, err := DecodeL4(payload)
+	if err != nil {
+		t.Fatalf("DecodeL4: %v", err)
+	}
+	if p.Protocol != unix.IPPROTO_UDP {
+		t.Fatalf("protocol = %d, want %d (extension headers not walked)", p.Protocol, unix.IPPROTO_UDP)
+	}
+	if p.SrcPort != 4000 || p.DstPort != 4000 {
+		t.Fatalf("ports = %d/%d, want 4000/4000", p.SrcPort, p.DstPort)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/binary"
 	"log"
+	"time"
 
 	"github.com/mdlayher/netlink"
 	"github.com/mdlayher/netlink/nlenc"
@@ -67,18 +68,85 @@ func (nfqueue *Nfqueue) Close() error {
 
 // SetVerdict signals the kernel the next action for a specified package id
 func (nfqueue *Nfqueue) SetVerdict(id, verdict int) (uint32, error) {
-	_, err := nfqueue.setVerdict(id, verdict, false)
+	_, err := nfqueue.setVerdict(id, verdict, false, nil)
 	return 0, err
 
 }
 
 // SetVerdictBatch signals the kernel the next action for a batch of packages till id
 func (nfqueue *Nfqueue) SetVerdictBatch(id, verdict int) (uint32, error) {
-	_, err := nfqueue.setVerdict(id, verdict, true)
-	return 0, err
+	return nfqueue.setVerdictAck(id, verdict, true, nil, true)
+}
+
+// SetReadBuffer sets the size in bytes of the underlying socket's receive
+// buffer. It tries SO_RCVBUFFORCE first, which may exceed net.core.rmem_max
+// but needs CAP_NET_ADMIN, and falls back to SO_RCVBUF, which the kernel
+// caps at net.core.rmem_max. Raising this is useful for busy queues that
+// would otherwise drop packets between reads.
+func (nfqueue *Nfqueue) SetReadBuffer(size int) error {
+	return nfqueue.setSockoptInt(unix.SO_RCVBUF, unix.SO_RCVBUFFORCE, size)
+}
+
+// SetWriteBuffer sets the size in bytes of the underlying socket's send
+// buffer. It tries SO_SNDBUFFORCE first, which may exceed net.core.wmem_max
+// but needs CAP_NET_ADMIN, and falls back to SO_SNDBUF, which the kernel
+// caps at net.core.wmem_max.
+func (nfqueue *Nfqueue) SetWriteBuffer(size int) error {
+	return nfqueue.setSockoptInt(unix.SO_SNDBUF, unix.SO_SNDBUFFORCE, size)
+}
+
+func (nfqueue *Nfqueue) setSockoptInt(opt, forceOpt, size int) error {
+	sc, err := nfqueue.Con.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := sc.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, forceOpt, size)
+		if sockErr != nil {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, opt, size)
+		}
+	}); err != nil {
+		return err
+	}
+	return sockErr
 }
 
-func (nfqueue *Nfqueue) setVerdict(id, verdict int, batch bool) (uint32, error) {
+// SetVerdictModPacket signals the kernel the next action for a specified
+// package id and replaces its payload with packet before the kernel
+// reinjects it. packet must be a complete L3 packet the kernel can send
+// back onto the wire; payloads larger than the queue's configured copy
+// buffer size are rejected rather than silently truncated by the kernel.
+func (nfqueue *Nfqueue) SetVerdictModPacket(id, verdict int, packet []byte) error {
+	if len(packet) > int(binary.BigEndian.Uint32(nfqueue.bufsize)) {
+		return ErrPacketTooBig
+	}
+	_, err := nfqueue.setVerdict(id, verdict, false, []netlink.Attribute{
+		{Type: nfQaPayload, Data: packet},
+	})
+	return err
+}
+
+// SetVerdictWithMark signals the kernel the next action for a specified
+// package id and attaches mark as the packet's new NFQA_MARK value.
+func (nfqueue *Nfqueue) SetVerdictWithMark(id, verdict int, mark uint32) error {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, mark)
+	_, err := nfqueue.setVerdict(id, verdict, false, []netlink.Attribute{
+		{Type: nfQaMark, Data: data},
+	})
+	return err
+}
+
+func (nfqueue *Nfqueue) setVerdict(id, verdict int, batch bool, attrs []netlink.Attribute) (uint32, error) {
+	return nfqueue.setVerdictAck(id, verdict, batch, attrs, true)
+}
+
+// setVerdictAck is setVerdict with control over whether the kernel is asked
+// to acknowledge the message. Callers that flush many verdicts per second
+// (see VerdictBatch) can opt out of the ack round-trip once they've accepted
+// that a send error is the only feedback they'll get.
+func (nfqueue *Nfqueue) setVerdictAck(id, verdict int, batch bool, attrs []netlink.Attribute, ack bool) (uint32, error) {
 	/*
 		struct nfqnl_msg_verdict_hdr {
 			__be32 verdict;
@@ -93,17 +161,20 @@ func (nfqueue *Nfqueue) setVerdict(id, verdict int, batch bool) (uint32, error)
 	buf := make([]byte, 4)
 	binary.BigEndian.PutUint32(buf, uint32(id))
 	verdictData := append([]byte{0x0, 0x0, 0x0, byte(verdict)}, buf...)
-	cmd, err := netlink.MarshalAttributes([]netlink.Attribute{
-		{Type: nfQaVerdictHdr, Data: verdictData},
-	})
+	verdictAttrs := append([]netlink.Attribute{{Type: nfQaVerdictHdr, Data: verdictData}}, attrs...)
+	cmd, err := netlink.MarshalAttributes(verdictAttrs)
 	if err != nil {
 		return 0, err
 	}
 	data := putExtraHeader(nfqueue.family, unix.NFNETLINK_V0, nfqueue.queue)
 	data = append(data, cmd...)
+	flags := netlink.HeaderFlagsRequest
+	if ack {
+		flags |= netlink.HeaderFlagsAcknowledge
+	}
 	req := netlink.Message{
 		Header: netlink.Header{
-			Flags:    netlink.HeaderFlagsRequest | netlink.HeaderFlagsAcknowledge,
+			Flags:    flags,
 			Sequence: 0,
 		},
 		Data: data,
@@ -113,18 +184,29 @@ func (nfqueue *Nfqueue) setVerdict(id, verdict int, batch bool) (uint32, error)
 	} else {
 		req.Header.Type = netlink.HeaderType((nfnlSubSysQueue << 8) | nfQnlMsgVerdict)
 	}
+	if !ack {
+		_, err := nfqueue.Con.Send(req)
+		return 0, err
+	}
 	return nfqueue.execute(req)
 }
 
-// Register your own function as callback for a netfilter log group
-func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, log *log.Logger, fn HookFunc) error {
+// Register your own function as callback for a netfilter queue. The
+// returned channel receives at most one error - a receive/parse failure, or
+// nil once ctx is cancelled and the receive loop has shut down cleanly - and
+// is then closed.
+func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, logger *log.Logger, fn HookFunc, opts ...Option) (<-chan error, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	// unbinding existing handler (if any)
 	seq, err := nfqueue.setConfig(nfqueue.family, 0, 0, []netlink.Attribute{
 		{Type: nfQaCfgCmd, Data: []byte{nfUlnlCfgCmdPfUnbind, 0x0, 0x0, byte(nfqueue.family)}},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// binding to family
@@ -132,7 +214,7 @@ func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, log *log.Lo
 		{Type: nfQaCfgCmd, Data: []byte{nfUlnlCfgCmdPfBind, 0x0, 0x0, byte(nfqueue.family)}},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// binding to generic queue
@@ -140,7 +222,7 @@ func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, log *log.Lo
 		{Type: nfQaCfgCmd, Data: []byte{nfUlnlCfgCmdBind, 0x0, 0x0, byte(nfqueue.family)}},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// binding to the requested queue
@@ -148,7 +230,7 @@ func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, log *log.Lo
 		{Type: nfQaCfgCmd, Data: []byte{nfUlnlCfgCmdBind, 0x0, 0x0, byte(nfqueue.family)}},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// set copy mode and buffer size
@@ -157,7 +239,7 @@ func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, log *log.Lo
 		{Type: nfQaCfgParams, Data: data},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var attrs []netlink.Attribute
@@ -170,24 +252,55 @@ func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, log *log.Lo
 	if len(attrs) != 0 {
 		_, err = nfqueue.setConfig(uint8(unix.AF_UNSPEC), seq, nfqueue.queue, attrs)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	errChan := make(chan error, 1)
+
+	// unblocks Con.Receive() as soon as ctx is cancelled, instead of
+	// leaving the receive loop parked until the next packet or a socket
+	// error from an unrelated Close() on another goroutine
+	unblock := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			nfqueue.Con.SetReadDeadline(time.Now())
+		case <-unblock:
+		}
+	}()
+
 	go func() {
+		defer close(unblock)
+		defer close(errChan)
 		defer func() {
-			// unbinding from queue
-			_, err = nfqueue.setConfig(uint8(unix.AF_UNSPEC), seq, nfqueue.queue, []netlink.Attribute{
+			// clear any deadline armed by the watcher goroutine above -
+			// otherwise the unbind's own ack-wait below inherits a deadline
+			// that has already passed and fails on every clean shutdown
+			nfqueue.Con.SetReadDeadline(time.Time{})
+			// unbinding from queue, while the socket is still open
+			if _, err := nfqueue.setConfig(uint8(unix.AF_UNSPEC), seq, nfqueue.queue, []netlink.Attribute{
 				{Type: nfQaCfgCmd, Data: []byte{nfUlnlCfgCmdUnbind, 0x0, 0x0, byte(nfqueue.family)}},
-			})
-			if err != nil {
-				log.Printf("Could not unbind from queue: %v", err)
-				return
+			}); err != nil {
+				logger.Printf("Could not unbind from queue: %v", err)
 			}
 		}()
+		var scratch Msg
+		if o.pooled {
+			scratch = make(Msg)
+		}
 		for {
+			// Con.Receive() already drains every netlink message the kernel
+			// handed back for this read, so this loop amounts to a
+			// recvmmsg-style bulk read: one syscall, many queued packets
+			// processed off of it before going back to the kernel for more.
 			reply, err := nfqueue.Con.Receive()
 			if err != nil {
+				if ctx.Err() != nil {
+					// shutdown requested via SetReadDeadline above
+					return
+				}
+				errChan <- err
 				return
 			}
 
@@ -197,19 +310,20 @@ func (nfqueue *Nfqueue) Register(ctx context.Context, copyMode byte, log *log.Lo
 					// continue to receive messages
 					break
 				}
-				m, err := parseMsg(msg)
+				m, err := parseMsg(msg, o.decoder, scratch)
 				if err != nil {
-					log.Printf("Could not parse message: %v", err)
+					errChan <- err
 					return
 				}
-				if ret := fn(m); ret != 0 {
+				ret := fn(m)
+				if ret != 0 {
 					return
 				}
 			}
 		}
 	}()
 
-	return nil
+	return errChan, nil
 }
 
 // /include/uapi/linux/netfilter/nfnetlink.h:struct nfgenmsg{} res_id is Big Endian
@@ -281,7 +395,7 @@ func unmarschalErrMsg(b []byte) (ErrMsg, error) {
 	return msg, nil
 }
 
-func parseMsg(msg netlink.Message) (Msg, error) {
+func parseMsg(msg netlink.Message, decoder Decoder, scratch Msg) (Msg, error) {
 	if msg.Header.Type&netlink.HeaderTypeError == netlink.HeaderTypeError {
 		errMsg, err := unmarschalErrMsg(msg.Data)
 		if err != nil {
@@ -289,7 +403,7 @@ func parseMsg(msg netlink.Message) (Msg, error) {
 		}
 		return nil, errors.Wrapf(ErrRecvMsg, "%#v", errMsg)
 	}
-	m, err := extractAttributes(msg.Data)
+	m, err := extractAttributes(msg.Data, decoder, scratch)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,50 @@
+//+build linux
+
+package nfqueue
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestRegisterCancelNoLeak starts and cancels 100 registrations back-to-back
+// and asserts that each receive loop exits cleanly once its context is
+// cancelled, proving Register doesn't leak the read-loop goroutine or the
+// underlying fd when shutdown is driven via ctx instead of a socket error.
+func TestRegisterCancelNoLeak(t *testing.T) {
+	logger := log.New(os.Stderr, "", 0)
+
+	for i := 0; i < 100; i++ {
+		nf, err := Open(unix.AF_INET, 100)
+		if err != nil {
+			t.Skipf("could not open netfilter queue socket (requires CAP_NET_ADMIN): %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errChan, err := nf.Register(ctx, 0, logger, func(m Msg) int { return 0 })
+		if err != nil {
+			nf.Close()
+			t.Skipf("could not register queue (requires CAP_NET_ADMIN): %v", err)
+		}
+
+		cancel()
+
+		select {
+		case err := <-errChan:
+			if err != nil {
+				t.Fatalf("iteration %d: receive loop reported error on cancel: %v", i, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("iteration %d: receive loop did not exit after ctx cancellation", i)
+		}
+
+		if err := nf.Close(); err != nil {
+			t.Fatalf("iteration %d: Close: %v", i, err)
+		}
+	}
+}
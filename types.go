@@ -0,0 +1,93 @@
+//+build linux
+
+package nfqueue
+
+import "github.com/pkg/errors"
+
+// Verdicts for a queued packet. SetVerdict and its variants accept all of
+// them except NfStop, for which they return ErrInvalidVerdict.
+const (
+	NfDrop   = 0
+	NfAccept = 1
+	NfStolen = 2
+	NfQeueue = 3
+	NfRepeat = 4
+	NfStop   = 5
+)
+
+// nfnetlink subsystem and message types, see
+// include/uapi/linux/netfilter/nfnetlink_queue.h
+const (
+	nfnlSubSysQueue = 3
+
+	nfQnlMsgPacket       = 0
+	nfQnlMsgVerdict      = 1
+	nfQnlMsgConfig       = 2
+	nfQnlMsgVerdictBatch = 3
+)
+
+// NFQA_* attribute types carried in a NFQNL_MSG_PACKET/VERDICT message.
+const (
+	nfQaUnspec            = 0
+	nfQaPacketHdr         = 1
+	nfQaVerdictHdr        = 2
+	nfQaMark              = 3
+	nfQaTimestamp         = 4
+	nfQaIfIndexIndev      = 5
+	nfQaIfIndexOutdev     = 6
+	nfQaIfIndexPhysIndev  = 7
+	nfQaIfIndexPhysOutdev = 8
+	nfQaHwAddr            = 9
+	nfQaPayload           = 10
+	nfQaCt                = 11
+	nfQaCtInfo            = 12
+	nfQaCapLen            = 13
+	nfQaSkbInfo           = 14
+	nfQaExp               = 15
+	nfQaUID               = 16
+	nfQaGID               = 17
+	nfQaSecCtx            = 18
+	nfQaVlan              = 19
+	nfQaL2Hdr             = 20
+)
+
+// NFQA_CFG_* attribute types used to configure a queue.
+const (
+	nfQaCfgUnspec      = 0
+	nfQaCfgCmd         = 1
+	nfQaCfgParams      = 2
+	nfQaCfgQueueMaxLen = 3
+	nfQaCfgMask        = 4
+	nfQaCfgFlags       = 5
+)
+
+// NFQNL_CFG_CMD_* values carried in a nfQaCfgCmd attribute.
+const (
+	nfUlnlCfgCmdNone     = 0
+	nfUlnlCfgCmdBind     = 1
+	nfUlnlCfgCmdUnbind   = 2
+	nfUlnlCfgCmdPfBind   = 3
+	nfUlnlCfgCmdPfUnbind = 4
+)
+
+// NFQNL_CFG_F_* queue behaviour flags, settable via SetFlag.
+const (
+	NfQaCfgFlagFailOpen  = 0x0001
+	NfQaCfgFlagConntrack = 0x0002
+	NfQaCfgFlagGSO       = 0x0004
+	NfQaCfgFlagUIDGID    = 0x0008
+	NfQaCfgFlagSecCtx    = 0x0010
+	nfQaCfgFlagMax       = 0x0020
+)
+
+// Errors returned by this package.
+var (
+	ErrAfFamily       = errors.New("unsupported address family")
+	ErrInvFlag        = errors.New("invalid queue flag")
+	ErrInvalidVerdict = errors.New("invalid verdict")
+	ErrUnexpMsg       = errors.New("received unexpected message from the kernel")
+	ErrRecvMsg        = errors.New("could not receive message")
+	ErrPacketTooBig   = errors.New("packet is larger than the queue's configured copy buffer size")
+	ErrNilConntrack   = errors.New("conntrack entry must not be nil")
+	ErrOutOfOrderID   = errors.New("packet id added to VerdictBatch out of order")
+)